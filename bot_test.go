@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+func newTestTexporter(t *testing.T) *Texporter {
+	t.Helper()
+
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("error during opening in-memory store\n%v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return &Texporter{
+		RedmineAPIKey:  "test-key",
+		RedmineBaseURL: "https://example.invalid/",
+		HTTPClient:     http.DefaultClient,
+		Logger:         zap.NewNop().Sugar(),
+		Store:          store,
+		sessions:       make(map[int64]*session),
+		wg:             &sync.WaitGroup{},
+		ProjectList: []Project{
+			{ID: "1", Name: "Demo", ChatID: 100, TestChatID: 200, Tracker: "task", Export: false},
+		},
+	}
+}
+
+func messageText(t *testing.T, reply tgbotapi.Chattable) string {
+	t.Helper()
+
+	msg, ok := reply.(tgbotapi.MessageConfig)
+	if !ok {
+		t.Fatalf("expected a MessageConfig reply, got %T", reply)
+	}
+
+	return msg.Text
+}
+
+// TestHandleUpdateSelfDrivenFlow self-clicks through message -> "export" ->
+// a date -> "yes", asserting the replies HandleUpdate would have sent and
+// the session state it leaves behind - without touching the network.
+func TestHandleUpdateSelfDrivenFlow(t *testing.T) {
+	texp := newTestTexporter(t)
+	chatID := int64(555)
+	ctx := context.Background()
+
+	reply, err := texp.HandleUpdate(ctx, tgbotapi.Update{
+		Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+	})
+	if err != nil {
+		t.Fatalf("error during handling message update\n%v", err)
+	}
+	if got, want := messageText(t, reply), "что будем делать, ммм?"; got != want {
+		t.Errorf("reply text = %q, want %q", got, want)
+	}
+	if texp.sessions[chatID].State != "type" {
+		t.Errorf("session state = %q, want %q", texp.sessions[chatID].State, "type")
+	}
+
+	reply, err = texp.HandleUpdate(ctx, tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+			Data:    "export",
+		},
+	})
+	if err != nil {
+		t.Fatalf("error during handling export callback\n%v", err)
+	}
+	if got, want := messageText(t, reply), "а за какой день?"; got != want {
+		t.Errorf("reply text = %q, want %q", got, want)
+	}
+	if texp.sessions[chatID].State != "date" {
+		t.Errorf("session state = %q, want %q", texp.sessions[chatID].State, "date")
+	}
+
+	reply, err = texp.HandleUpdate(ctx, tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+			Data:    "2024-03-05",
+		},
+	})
+	if err != nil {
+		t.Fatalf("error during handling date callback\n%v", err)
+	}
+	if texp.sessions[chatID].State != "ready" || texp.sessions[chatID].Date != "2024-03-05" {
+		t.Errorf("session after date step = %+v", texp.sessions[chatID])
+	}
+
+	reply, err = texp.HandleUpdate(ctx, tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+			Data:    "yes",
+		},
+	})
+	if err != nil {
+		t.Fatalf("error during handling yes callback\n%v", err)
+	}
+	if got, want := messageText(t, reply), "я закончил!"; got != want {
+		t.Errorf("reply text = %q, want %q", got, want)
+	}
+	if _, ok := texp.sessions[chatID]; ok {
+		t.Errorf("session for chat %v should have been reset after export", chatID)
+	}
+}
+
+// TestGetListTimeEntriesMocked exercises getListTimeEntries against a
+// httptest.Server standing in for Redmine, via the HTTPClient/RedmineBaseURL
+// injection points.
+func TestGetListTimeEntriesMocked(t *testing.T) {
+	canned := TimeEntryListResponse{
+		TimeEntries: []TimeEntryResponse{
+			{
+				ID:      1,
+				Project: NameAndID{ID: 1, Name: "Demo"},
+				Issue:   ID{ID: 42},
+				User:    NameAndID{ID: 7, Name: "Alice"},
+				Hours:   3,
+				SpentOn: "2024-03-05",
+			},
+		},
+		TotalCount: 1,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(canned); err != nil {
+			t.Fatalf("error during encoding canned response\n%v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	texp := newTestTexporter(t)
+	texp.RedmineBaseURL = server.URL + "/"
+	texp.HTTPClient = server.Client()
+
+	entries, err := texp.getListTimeEntries("2024-03-05", "1")
+	if err != nil {
+		t.Fatalf("error during getListTimeEntries\n%v", err)
+	}
+
+	if len(entries) != 1 || entries[0].User.Name != "Alice" {
+		t.Errorf("entries = %+v, want a single entry from Alice", entries)
+	}
+}
+
+// TestGetProjectMembersMocked exercises getProjectMembers against a
+// httptest.Server standing in for Redmine, asserting group memberships (no
+// "user" field) are filtered out.
+func TestGetProjectMembersMocked(t *testing.T) {
+	canned := MembershipListResponse{
+		Memberships: []MembershipResponse{
+			{ID: 1, Project: NameAndID{ID: 1, Name: "Demo"}, User: NameAndID{ID: 7, Name: "Alice"}},
+			{ID: 2, Project: NameAndID{ID: 1, Name: "Demo"}, User: NameAndID{ID: 8, Name: "Bob"}},
+			{ID: 3, Project: NameAndID{ID: 1, Name: "Demo"}}, // a group membership, no user
+		},
+		TotalCount: 3,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(canned); err != nil {
+			t.Fatalf("error during encoding canned response\n%v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	texp := newTestTexporter(t)
+	texp.RedmineBaseURL = server.URL + "/"
+	texp.HTTPClient = server.Client()
+
+	members, err := texp.getProjectMembers("1")
+	if err != nil {
+		t.Fatalf("error during getProjectMembers\n%v", err)
+	}
+
+	if len(members) != 2 || members[0].Name != "Alice" || members[1].Name != "Bob" {
+		t.Errorf("members = %+v, want Alice and Bob", members)
+	}
+}
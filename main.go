@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -24,10 +29,14 @@ type Project struct {
 
 type Config struct {
 	RedmineAPIKey    string    `json:"redmine_api_key"`
+	RedmineBaseURL   string    `json:"redmine_base_url"`
 	TelegramBotToken string    `json:"telegram_bot_token"`
 	ProjectList      []Project `json:"projects"`
+	DataPath         string    `json:"data_path"`
 }
 
+const defaultRedmineBaseURL = "https://support.bergen.tech/"
+
 type NameAndID struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
@@ -57,18 +66,61 @@ type TimeEntryListResponse struct {
 	Limit       int                 `json:"limit"`
 }
 
+type MembershipResponse struct {
+	ID      int64     `json:"id"`
+	Project NameAndID `json:"project"`
+	User    NameAndID `json:"user"`
+}
+
+type MembershipListResponse struct {
+	Memberships []MembershipResponse `json:"memberships"`
+	TotalCount  int                  `json:"total_count"`
+	Offset      int                  `json:"offset"`
+	Limit       int                  `json:"limit"`
+}
+
 type Texporter struct {
-	RedmineAPIKey string
-	TelegramBot   *tgbotapi.BotAPI
-	ProjectList   []Project
-	Logger        *zap.SugaredLogger
-	Model         model
+	RedmineAPIKey  string
+	RedmineBaseURL string
+	HTTPClient     *http.Client
+	TelegramBot    *tgbotapi.BotAPI
+	ProjectList    []Project
+	Logger         *zap.SugaredLogger
+	Store          *Store
+	IdleTimeout    time.Duration
+	sessions       map[int64]*session
+	wg             *sync.WaitGroup
 }
 
-type model struct {
-	state  string
-	isTest bool
-	date   string
+// sessionFor returns the in-flight session for chatID, creating a fresh
+// in-memory one if none exists yet; it does not persist it, callers do that
+// with saveSession.
+func (t Texporter) sessionFor(chatID int64) *session {
+	sess, ok := t.sessions[chatID]
+	if !ok {
+		sess = &session{ChatID: chatID}
+		t.sessions[chatID] = sess
+	}
+
+	return sess
+}
+
+// saveSession persists the session and logs on failure, mirroring how
+// exportTimeEntries treats storage/Telegram errors as non-fatal.
+func (t Texporter) saveSession(sess *session) {
+	if err := t.Store.SaveSession(sess); err != nil {
+		t.Logger.Errorw("error during saving session", "Chat ID", sess.ChatID, "Error", err)
+	}
+}
+
+// resetSession clears the in-flight dialog for chatID both in memory and in
+// the database.
+func (t Texporter) resetSession(chatID int64) {
+	delete(t.sessions, chatID)
+
+	if err := t.Store.DeleteSession(chatID); err != nil {
+		t.Logger.Errorw("error during deleting session", "Chat ID", chatID, "Error", err)
+	}
 }
 
 var typesKeyboard = tgbotapi.NewInlineKeyboardMarkup(
@@ -78,6 +130,11 @@ var typesKeyboard = tgbotapi.NewInlineKeyboardMarkup(
 	tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("напоминаем списаться", "notification"),
 	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("текст", "mode_text"),
+		tgbotapi.NewInlineKeyboardButtonData("картинка", "mode_image"),
+		tgbotapi.NewInlineKeyboardButtonData("pdf", "mode_pdf"),
+	),
 )
 
 var readyKeyboard = tgbotapi.NewInlineKeyboardMarkup(
@@ -112,12 +169,45 @@ func NewTexporter() (Texporter, error) {
 
 	t.RedmineAPIKey = config.RedmineAPIKey
 
+	t.RedmineBaseURL = config.RedmineBaseURL
+	if t.RedmineBaseURL == "" {
+		t.RedmineBaseURL = defaultRedmineBaseURL
+	}
+
+	t.HTTPClient = &http.Client{}
+
 	t.TelegramBot, err = tgbotapi.NewBotAPI(config.TelegramBotToken)
 	if err != nil {
 		return Texporter{}, fmt.Errorf("error during Telegram Bot creating\n%v", err)
 	}
 
-	t.Model = model{}
+	dataPath := config.DataPath
+	if envPath := os.Getenv("TGTX_DATA_PATH"); envPath != "" {
+		dataPath = envPath
+	}
+	if dataPath == "" {
+		dataPath = "texporter.db"
+	}
+
+	t.Store, err = NewStore(dataPath)
+	if err != nil {
+		return Texporter{}, fmt.Errorf("error during opening data store at '%v'\n%v", dataPath, err)
+	}
+
+	t.sessions, err = t.Store.LoadSessions()
+	if err != nil {
+		return Texporter{}, fmt.Errorf("error during resuming sessions\n%v", err)
+	}
+
+	t.wg = &sync.WaitGroup{}
+
+	if idleTimeout := os.Getenv("TGTX_IDLE_TIMEOUT"); idleTimeout != "" {
+		t.IdleTimeout, err = time.ParseDuration(idleTimeout)
+		if err != nil {
+			t.Logger.Errorw("error during parsing TGTX_IDLE_TIMEOUT, idle auto-exit disabled", "Value", idleTimeout, "Error", err)
+			t.IdleTimeout = 0
+		}
+	}
 
 	t.TelegramBot.Debug = true
 
@@ -125,9 +215,7 @@ func NewTexporter() (Texporter, error) {
 }
 
 func (t Texporter) getListTimeEntries(date string, project string) ([]TimeEntryResponse, error) {
-	client := &http.Client{}
-
-	url := "https://support.bergen.tech/" + "time_entries.json?key=" + t.RedmineAPIKey
+	url := t.RedmineBaseURL + "time_entries.json?key=" + t.RedmineAPIKey
 
 	params := make([]string, 0)
 	params = append(params,
@@ -144,7 +232,7 @@ func (t Texporter) getListTimeEntries(date string, project string) ([]TimeEntryR
 		return []TimeEntryResponse{}, fmt.Errorf("error during request creating with url - %v\n%v", url, err)
 	}
 
-	resp, err := client.Do(req)
+	resp, err := t.HTTPClient.Do(req)
 	if err != nil {
 		return []TimeEntryResponse{}, fmt.Errorf("error during request doing with request - %v\n%v", req, err)
 	}
@@ -168,9 +256,54 @@ func (t Texporter) getListTimeEntries(date string, project string) ([]TimeEntryR
 	return teList.TimeEntries, nil
 }
 
+// getProjectMembers returns the users (not groups) Redmine has as members of
+// project, i.e. who is expected to log time against it.
+func (t Texporter) getProjectMembers(project string) ([]NameAndID, error) {
+	url := t.RedmineBaseURL + "projects/" + project + "/memberships.json?key=" + t.RedmineAPIKey
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error during request creating with url - %v\n%v", url, err)
+	}
+
+	resp, err := t.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error during request doing with request - %v\n%v", req, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status code not in 2xx range with request -%v", req)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error during read response body\n%v", err)
+	}
+
+	membershipList := MembershipListResponse{}
+
+	if err := json.Unmarshal(body, &membershipList); err != nil {
+		return nil, fmt.Errorf("error during unmarshaling body with memberships response - \n%v", err)
+	}
+
+	members := make([]NameAndID, 0, len(membershipList.Memberships))
+	for _, m := range membershipList.Memberships {
+		if m.User.ID == 0 {
+			continue // a group membership, not an individual user
+		}
+
+		members = append(members, m.User)
+	}
+
+	return members, nil
+}
+
 // detect last work date before today, if offset != 0 - detect 'last work date minus offset'
-func prevWorkDate(offset int) string {
-	today := time.Now()
+func prevWorkDate(offset int, loc *time.Location) string {
+	return prevWorkDateFrom(time.Now().In(loc), offset)
+}
+
+func prevWorkDateFrom(today time.Time, offset int) string {
 	if today.Weekday() == time.Monday {
 		return today.AddDate(0, 0, offset-3).Format("2006-01-02")
 	}
@@ -179,6 +312,9 @@ func prevWorkDate(offset int) string {
 }
 
 func (t Texporter) sendTextToChannel(chatID int64, text string) error {
+	t.wg.Add(1)
+	defer t.wg.Done()
+
 	msg := tgbotapi.NewMessage(chatID, text)
 
 	_, err := t.TelegramBot.Send(msg)
@@ -189,7 +325,7 @@ func (t Texporter) sendTextToChannel(chatID int64, text string) error {
 	return nil
 }
 
-func (t Texporter) exportTimeEntries(date string, isTest bool) {
+func (t Texporter) exportTimeEntries(date string, isTest bool, mode string) {
 	for _, p := range t.ProjectList {
 		if !p.Export {
 			continue
@@ -212,6 +348,9 @@ func (t Texporter) exportTimeEntries(date string, isTest bool) {
 		}
 		// key - user id; value - message text to export
 		messages := make(map[int64]string)
+		// key - user id; value - user name and number of entries, for the audit row
+		users := make(map[int64]NameAndID)
+		counts := make(map[int64]int)
 
 		for _, te := range timeEntries {
 			_, ok := messages[te.User.ID]
@@ -238,30 +377,63 @@ func (t Texporter) exportTimeEntries(date string, isTest bool) {
 
 				messages[te.User.ID] += mess
 			}
+
+			users[te.User.ID] = te.User
+			counts[te.User.ID]++
 		}
 
-		for _, mess := range messages {
-			err = t.sendTextToChannel(chatID, mess)
-			if err != nil {
-				t.Logger.Errorw("error during sending message to telegram channel",
+		if mode == "image" || mode == "pdf" {
+			if !t.sendRenderedReport(chatID, p, date, timeEntries, mode) {
+				t.sendTextMessages(chatID, p, messages)
+			}
+		} else {
+			t.sendTextMessages(chatID, p, messages)
+		}
+
+		for userID := range messages {
+			audit := exportAudit{
+				Project:      p.Name,
+				Date:         date,
+				User:         users[userID].Name,
+				ChatID:       chatID,
+				MessageCount: counts[userID],
+			}
+
+			if err := t.Store.LogExport(audit); err != nil {
+				t.Logger.Errorw("error during logging export audit row",
 					"Project Name", p.Name,
-					"Telegram channel ID", chatID,
-					"Message text", mess,
+					"User", users[userID].Name,
 					"Error", err,
 				)
-			} else {
-				t.Logger.Infow("success sent message to channel",
-					"Project name", p.Name,
-					"Telegram channel ID", chatID,
-					"Message text", mess,
-				)
 			}
 		}
 	}
 }
 
-func newDateKeyboard() tgbotapi.InlineKeyboardMarkup {
-	today := time.Now()
+// sendTextMessages posts one plaintext message per user, the original and
+// still default way to deliver an export.
+func (t Texporter) sendTextMessages(chatID int64, p Project, messages map[int64]string) {
+	for _, mess := range messages {
+		err := t.sendTextToChannel(chatID, mess)
+		if err != nil {
+			t.Logger.Errorw("error during sending message to telegram channel",
+				"Project Name", p.Name,
+				"Telegram channel ID", chatID,
+				"Message text", mess,
+				"Error", err,
+			)
+		} else {
+			t.Logger.Infow("success sent message to channel",
+				"Project name", p.Name,
+				"Telegram channel ID", chatID,
+				"Message text", mess,
+			)
+		}
+	}
+}
+
+func newDateKeyboard(loc *time.Location) tgbotapi.InlineKeyboardMarkup {
+	today := time.Now().In(loc)
 	dates := [5]string{
 		today.AddDate(0, 0, -1).Format("2006-01-02"),
 		today.AddDate(0, 0, -2).Format("2006-01-02"),
@@ -289,84 +461,214 @@ func newDateKeyboard() tgbotapi.InlineKeyboardMarkup {
 	)
 }
 
+// HandleUpdate is the bot's whole decision logic: given an update, it
+// advances (and persists) session state and returns what should be sent
+// back, if anything. This is what makes the bot testable - a test can
+// synthesize updates and inspect the replies without a real Telegram
+// connection. The one exception is confirming an export ("yes" in the ready
+// step): that runs exportTimeEntries directly, which queries Redmine and
+// posts the export to the project chats itself; only the closing "я
+// закончил!" is returned as the reply.
+func (t Texporter) HandleUpdate(ctx context.Context, update tgbotapi.Update) (tgbotapi.Chattable, error) {
+	switch {
+	case update.Message != nil:
+		return t.handleMessage(update.Message)
+	case update.CallbackQuery != nil:
+		return t.handleCallbackQuery(update.CallbackQuery)
+	}
+
+	return nil, nil
+}
+
+// chatIDFromUpdate extracts the chat an update came from, if any, so the
+// driver can reply to it even when HandleUpdate itself failed.
+func chatIDFromUpdate(update tgbotapi.Update) (int64, bool) {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID, true
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.Message.Chat.ID, true
+	}
+
+	return 0, false
+}
+
+func (t Texporter) handleMessage(message *tgbotapi.Message) (tgbotapi.Chattable, error) {
+	chatID := message.Chat.ID
+
+	switch message.Command() {
+	case "subscribe":
+		return t.startSubscribe(chatID)
+	case "unsubscribe":
+		return t.handleUnsubscribe(chatID, message.CommandArguments())
+	case "subs":
+		return t.handleSubs(chatID)
+	case "tz":
+		return t.handleSetTimezone(chatID, message.CommandArguments())
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "что будем делать, ммм?")
+	msg.ReplyMarkup = typesKeyboard
+
+	sess := t.sessionFor(chatID)
+	sess.State = "type"
+	t.saveSession(sess)
+
+	return msg, nil
+}
+
+func (t Texporter) handleCallbackQuery(query *tgbotapi.CallbackQuery) (tgbotapi.Chattable, error) {
+	chatID := query.Message.Chat.ID
+	sess := t.sessionFor(chatID)
+
+	switch sess.State {
+	case "type":
+		switch query.Data {
+		case "export":
+			msg := tgbotapi.NewMessage(chatID, "а за какой день?")
+			msg.ReplyMarkup = newDateKeyboard(t.locationFor(chatID))
+
+			sess.State = "date"
+			sess.IsTest = false
+			t.saveSession(sess)
+
+			return msg, nil
+		case "notification":
+			return t.startSubscribe(chatID)
+		case "mode_text", "mode_image", "mode_pdf":
+			sess.OutputMode = strings.TrimPrefix(query.Data, "mode_")
+			t.saveSession(sess)
+
+			return tgbotapi.NewMessage(chatID, fmt.Sprintf("формат выгрузки: %v", sess.OutputMode)), nil
+		}
+
+		return nil, nil
+	case "sub_project":
+		return t.handleSubscribeProject(chatID, sess, query.Data)
+	case "sub_schedule":
+		return t.handleSubscribeSchedule(chatID, sess, query.Data)
+	case "sub_time":
+		return t.handleSubscribeTime(chatID, sess, query.Data)
+	case "date":
+		sess.State = "ready"
+		sess.Date = query.Data
+
+		// agregate all project for time entries export
+		projectsForExport := make([]string, 0)
+		for _, p := range t.ProjectList {
+			if p.Export {
+				projectsForExport = append(projectsForExport, p.Name)
+			}
+		}
+
+		sess.Projects = projectsForExport
+		t.saveSession(sess)
+
+		msg := tgbotapi.NewMessage(
+			chatID,
+			fmt.Sprintf("давай повторим - выгружаю списания на проектах: %v, за %v число", projectsForExport, sess.Date),
+		)
+		msg.ReplyMarkup = readyKeyboard
+
+		return msg, nil
+	case "ready":
+		defer t.resetSession(chatID)
+
+		if query.Data != "yes" {
+			return nil, nil
+		}
+
+		t.Logger.Debug("start test export all entries")
+		t.exportTimeEntries(sess.Date, sess.IsTest, sess.OutputMode)
+
+		return tgbotapi.NewMessage(chatID, "я закончил!"), nil
+	}
+
+	return nil, nil
+}
+
+// botRunAndServe is the thin driver: it pulls updates off Telegram's long
+// poll, acknowledges callbacks, and sends whatever HandleUpdate decided to
+// reply with. It shuts down cleanly on SIGINT/SIGTERM, and - if
+// TGTX_IDLE_TIMEOUT is set - also exits 0 after sitting idle that long with
+// no reminder due soon, so systemd socket activation can respawn it on the
+// next update.
 func (t Texporter) botRunAndServe() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	updateConfig := tgbotapi.NewUpdate(0)
 
 	updateConfig.Timeout = 30
 
 	updates := t.TelegramBot.GetUpdatesChan(updateConfig)
 
-	for update := range updates {
-		if update.Message != nil {
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "что будем делать, ммм?")
+	stopReminders := make(chan struct{})
+	defer close(stopReminders)
+	go t.runReminderTicker(stopReminders)
 
-			msg.ReplyMarkup = typesKeyboard
+	var idleC <-chan time.Time
+	if t.IdleTimeout > 0 {
+		idle := time.NewTimer(t.IdleTimeout)
+		defer idle.Stop()
+		idleC = idle.C
+	}
 
-			t.Model.state = "type"
+	shutdown := func() error {
+		t.TelegramBot.StopReceivingUpdates()
+		t.wg.Wait()
+		return nil
+	}
 
-			if _, err := t.TelegramBot.Send(msg); err != nil {
-				panic(err)
+	for {
+		select {
+		case <-ctx.Done():
+			t.Logger.Info("shutting down on signal")
+			return shutdown()
+		case <-idleC:
+			if t.hasUpcomingReminder(t.IdleTimeout) {
+				idleC = time.NewTimer(t.IdleTimeout).C
+				continue
 			}
-		} else if update.CallbackQuery != nil {
-			callback := tgbotapi.NewCallback(update.CallbackQuery.ID, update.CallbackQuery.Data)
-			if _, err := t.TelegramBot.Request(callback); err != nil {
-				panic(err)
-			}
-
-			switch t.Model.state {
-			case "type":
-				switch update.CallbackQuery.Data {
-				case "export":
-					msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, "а за какой день?")
-					msg.ReplyMarkup = newDateKeyboard()
-
-					t.Model.state = "date"
-					t.Model.isTest = false
-
-					if _, err := t.TelegramBot.Send(msg); err != nil {
-						panic(err)
-					}
-				}
-			case "date":
-				t.Model.state = "ready"
-				t.Model.date = update.CallbackQuery.Data
-
-				// agregate all project for time entries export
-				projectsForExport := make([]string, 0)
-				for _, p := range t.ProjectList {
-					if p.Export {
-						projectsForExport = append(projectsForExport, p.Name)
-					}
-				}
 
-				msg := tgbotapi.NewMessage(
-					update.CallbackQuery.Message.Chat.ID,
-					fmt.Sprintf("давай повторим - выгружаю списания на проектах: %v, за %v число", projectsForExport, t.Model.date),
-				)
+			t.Logger.Info("idle timeout reached, exiting for systemd socket activation to respawn")
+			return shutdown()
+		case update, ok := <-updates:
+			if !ok {
+				return shutdown()
+			}
 
-				msg.ReplyMarkup = readyKeyboard
+			if t.IdleTimeout > 0 {
+				idleC = time.NewTimer(t.IdleTimeout).C
+			}
 
-				if _, err := t.TelegramBot.Send(msg); err != nil {
+			if update.CallbackQuery != nil {
+				callback := tgbotapi.NewCallback(update.CallbackQuery.ID, update.CallbackQuery.Data)
+				if _, err := t.TelegramBot.Request(callback); err != nil {
 					panic(err)
 				}
-			case "ready":
-				if update.CallbackQuery.Data == "yes" {
-					msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, "я закончил!")
+			}
 
-					t.Logger.Debug("start test export all entries")
-					t.exportTimeEntries(t.Model.date, t.Model.isTest)
+			reply, err := t.HandleUpdate(ctx, update)
+			if err != nil {
+				t.Logger.Errorw("error during handling update", "Error", err)
 
-					if _, err := t.TelegramBot.Send(msg); err != nil {
-						panic(err)
+				if chatID, ok := chatIDFromUpdate(update); ok {
+					if _, sendErr := t.TelegramBot.Send(tgbotapi.NewMessage(chatID, "произошла ошибка, попробуйте еще раз")); sendErr != nil {
+						t.Logger.Errorw("error during sending error reply", "Chat ID", chatID, "Error", sendErr)
 					}
 				}
 
-				t.Model = model{}
+				continue
 			}
 
+			if reply != nil {
+				if _, err := t.TelegramBot.Send(reply); err != nil {
+					panic(err)
+				}
+			}
 		}
 	}
-	return nil
 }
 
 func main() {
@@ -375,6 +677,9 @@ func main() {
 		t.Logger.Fatal(err)
 	}
 	defer t.Logger.Sync()
+	defer t.Store.Close()
 
-	t.Logger.Fatal(t.botRunAndServe())
+	if err := t.botRunAndServe(); err != nil {
+		t.Logger.Fatal(err)
+	}
 }
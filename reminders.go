@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// reminderTimes lists the times offered by the subscribe flow's inline
+// keyboard, mirroring newDateKeyboard's fixed set of buttons.
+var reminderTimes = [4]string{"09:00", "12:00", "18:00", "20:00"}
+
+// reminderSchedules lists the schedules offered by the subscribe flow, paired
+// with the button label shown for each.
+var reminderSchedules = [2][2]string{
+	{"daily", "ежедневно"},
+	{"weekday", "по будням"},
+}
+
+func newProjectKeyboard(projects []Project) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(projects))
+	for _, p := range projects {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(p.Name, p.ID),
+		))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func newScheduleKeyboard() tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(reminderSchedules))
+	for _, s := range reminderSchedules {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(s[1], s[0]),
+		))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func newReminderTimeKeyboard() tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(reminderTimes))
+	for _, t := range reminderTimes {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(t, t),
+		))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// startSubscribe begins the /subscribe dialog: pick a project, then a
+// schedule, then a time.
+func (t Texporter) startSubscribe(chatID int64) (tgbotapi.Chattable, error) {
+	sess := t.sessionFor(chatID)
+	sess.State = "sub_project"
+	t.saveSession(sess)
+
+	msg := tgbotapi.NewMessage(chatID, "для какого проекта напоминать о списаниях?")
+	msg.ReplyMarkup = newProjectKeyboard(t.ProjectList)
+
+	return msg, nil
+}
+
+// handleSubscribeProject stores the chosen project and asks for a schedule.
+func (t Texporter) handleSubscribeProject(chatID int64, sess *session, project string) (tgbotapi.Chattable, error) {
+	sess.SubscribeProject = project
+	sess.State = "sub_schedule"
+	t.saveSession(sess)
+
+	msg := tgbotapi.NewMessage(chatID, "как часто напоминать?")
+	msg.ReplyMarkup = newScheduleKeyboard()
+
+	return msg, nil
+}
+
+// handleSubscribeSchedule stores the chosen schedule and asks for a time.
+func (t Texporter) handleSubscribeSchedule(chatID int64, sess *session, schedule string) (tgbotapi.Chattable, error) {
+	sess.SubscribeSchedule = schedule
+	sess.State = "sub_time"
+	t.saveSession(sess)
+
+	msg := tgbotapi.NewMessage(chatID, "во сколько напоминать?")
+	msg.ReplyMarkup = newReminderTimeKeyboard()
+
+	return msg, nil
+}
+
+// handleSubscribeTime finishes the /subscribe dialog by persisting the
+// subscription.
+func (t Texporter) handleSubscribeTime(chatID int64, sess *session, remindTime string) (tgbotapi.Chattable, error) {
+	sub := subscription{
+		ChatID:   chatID,
+		Project:  sess.SubscribeProject,
+		Schedule: sess.SubscribeSchedule,
+		Time:     remindTime,
+	}
+
+	if err := t.Store.AddSubscription(sub); err != nil {
+		t.Logger.Errorw("error during adding subscription", "Chat ID", chatID, "Error", err)
+	}
+
+	t.resetSession(chatID)
+
+	scheduleLabel := "по будням"
+	if sub.Schedule == "daily" {
+		scheduleLabel = "ежедневно"
+	}
+
+	return tgbotapi.NewMessage(chatID, fmt.Sprintf("готово, буду напоминать %v в %v", scheduleLabel, remindTime)), nil
+}
+
+// handleUnsubscribe implements "/unsubscribe [project_id]": with no
+// argument it drops every subscription for the chat, otherwise just the
+// named project.
+func (t Texporter) handleUnsubscribe(chatID int64, arg string) (tgbotapi.Chattable, error) {
+	subs, err := t.Store.ListSubscriptionsByChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sub := range subs {
+		if arg != "" && sub.Project != arg {
+			continue
+		}
+
+		if err := t.Store.RemoveSubscription(chatID, sub.Project); err != nil {
+			t.Logger.Errorw("error during removing subscription", "Chat ID", chatID, "Error", err)
+		}
+	}
+
+	return tgbotapi.NewMessage(chatID, "напоминания отключены"), nil
+}
+
+// handleSubs replies with the chat's current subscriptions.
+func (t Texporter) handleSubs(chatID int64) (tgbotapi.Chattable, error) {
+	subs, err := t.Store.ListSubscriptionsByChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(subs) == 0 {
+		return tgbotapi.NewMessage(chatID, "подписок нет"), nil
+	}
+
+	lines := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		lines = append(lines, fmt.Sprintf("%v - %v, %v", t.projectName(sub.Project), sub.Schedule, sub.Time))
+	}
+
+	return tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")), nil
+}
+
+// projectByID looks up a project by its Redmine ID, as used in
+// subscriptions, reports and sessions.
+func (t Texporter) projectByID(projectID string) (Project, bool) {
+	for _, p := range t.ProjectList {
+		if p.ID == projectID {
+			return p, true
+		}
+	}
+
+	return Project{}, false
+}
+
+func (t Texporter) projectName(projectID string) string {
+	if p, ok := t.projectByID(projectID); ok {
+		return p.Name
+	}
+
+	return projectID
+}
+
+// runReminderTicker wakes every minute, and for every subscription whose
+// schedule matches now, nudges the chat about every project member who has
+// not logged time yet today.
+func (t Texporter) runReminderTicker(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			t.checkReminders(now)
+		}
+	}
+}
+
+func (t Texporter) checkReminders(now time.Time) {
+	subs, err := t.Store.ListSubscriptions()
+	if err != nil {
+		t.Logger.Errorw("error during listing subscriptions for reminder check", "Error", err)
+		return
+	}
+
+	for _, sub := range subs {
+		local := now.In(t.locationFor(sub.ChatID))
+
+		if sub.Time != local.Format("15:04") {
+			continue
+		}
+
+		isWeekday := local.Weekday() >= time.Monday && local.Weekday() <= time.Friday
+		if sub.Schedule == "weekday" && !isWeekday {
+			continue
+		}
+
+		date := local.Format("2006-01-02")
+
+		entries, err := t.getListTimeEntries(date, sub.Project)
+		if err != nil {
+			t.Logger.Errorw("error during reminder check",
+				"Project ID", sub.Project,
+				"Error", err,
+			)
+			continue
+		}
+
+		members, err := t.getProjectMembers(sub.Project)
+		if err != nil {
+			t.Logger.Errorw("error during fetching project members for reminder check",
+				"Project ID", sub.Project,
+				"Error", err,
+			)
+			continue
+		}
+
+		missing := missingMembers(members, entries)
+		if len(missing) == 0 {
+			continue
+		}
+
+		mentions := make([]string, 0, len(missing))
+		for _, m := range missing {
+			mentions = append(mentions, "@"+m.Name)
+		}
+
+		text := fmt.Sprintf(
+			"напоминание: %v еще не списал(и) время по проекту %v за %v",
+			strings.Join(mentions, ", "),
+			t.projectName(sub.Project),
+			date,
+		)
+
+		if err := t.sendTextToChannel(sub.ChatID, text); err != nil {
+			t.Logger.Errorw("error during sending reminder",
+				"Chat ID", sub.ChatID,
+				"Project ID", sub.Project,
+				"Error", err,
+			)
+		}
+	}
+}
+
+// missingMembers returns the project members who have not logged any of
+// entries, i.e. who are due a reminder.
+func missingMembers(members []NameAndID, entries []TimeEntryResponse) []NameAndID {
+	logged := make(map[int64]bool, len(entries))
+	for _, e := range entries {
+		logged[e.User.ID] = true
+	}
+
+	missing := make([]NameAndID, 0, len(members))
+	for _, m := range members {
+		if !logged[m.ID] {
+			missing = append(missing, m)
+		}
+	}
+
+	return missing
+}
+
+// nextOccurrence returns when sub next fires at or after now, in now's
+// location.
+func nextOccurrence(now time.Time, sub subscription) time.Time {
+	hour, minute := 0, 0
+	fmt.Sscanf(sub.Time, "%d:%d", &hour, &minute)
+
+	candidate := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+
+	for candidate.Before(now) || (sub.Schedule == "weekday" && (candidate.Weekday() == time.Saturday || candidate.Weekday() == time.Sunday)) {
+		candidate = candidate.AddDate(0, 0, 1)
+	}
+
+	return candidate
+}
+
+// hasUpcomingReminder reports whether any subscription is due to fire
+// within window, so the idle-timeout auto-exit does not cut off a pending
+// reminder.
+func (t Texporter) hasUpcomingReminder(window time.Duration) bool {
+	subs, err := t.Store.ListSubscriptions()
+	if err != nil {
+		t.Logger.Errorw("error during listing subscriptions for idle check", "Error", err)
+		return false
+	}
+
+	now := time.Now()
+
+	for _, sub := range subs {
+		local := now.In(t.locationFor(sub.ChatID))
+		if nextOccurrence(local, sub).Sub(local) < window {
+			return true
+		}
+	}
+
+	return false
+}
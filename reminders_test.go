@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	cases := []struct {
+		name string
+		now  time.Time
+		sub  subscription
+		want time.Time
+	}{
+		{
+			name: "daily later today fires today",
+			now:  time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC), // Wednesday
+			sub:  subscription{Schedule: "daily", Time: "18:00"},
+			want: time.Date(2024, 3, 6, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "daily already passed today rolls to tomorrow",
+			now:  time.Date(2024, 3, 6, 20, 0, 0, 0, time.UTC),
+			sub:  subscription{Schedule: "daily", Time: "18:00"},
+			want: time.Date(2024, 3, 7, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekday skips the weekend",
+			now:  time.Date(2024, 3, 8, 20, 0, 0, 0, time.UTC), // Friday, after 18:00
+			sub:  subscription{Schedule: "weekday", Time: "18:00"},
+			want: time.Date(2024, 3, 11, 18, 0, 0, 0, time.UTC), // Monday
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := nextOccurrence(c.now, c.sub)
+			if !got.Equal(c.want) {
+				t.Errorf("nextOccurrence(%v, %+v) = %v, want %v", c.now, c.sub, got, c.want)
+			}
+		})
+	}
+}
+
+func TestMissingMembers(t *testing.T) {
+	members := []NameAndID{
+		{ID: 1, Name: "Alice"},
+		{ID: 2, Name: "Bob"},
+		{ID: 3, Name: "Carol"},
+	}
+	entries := []TimeEntryResponse{
+		{User: NameAndID{ID: 2, Name: "Bob"}},
+	}
+
+	missing := missingMembers(members, entries)
+	if len(missing) != 2 || missing[0].Name != "Alice" || missing[1].Name != "Carol" {
+		t.Errorf("missingMembers(...) = %+v, want Alice and Carol", missing)
+	}
+}
+
+func TestHasUpcomingReminder(t *testing.T) {
+	texp := newTestTexporter(t)
+
+	sub := subscription{ChatID: 1, Project: "1", Schedule: "daily", Time: "18:00"}
+	if err := texp.Store.AddSubscription(sub); err != nil {
+		t.Fatalf("error during adding subscription\n%v", err)
+	}
+
+	soon := nextOccurrence(time.Now().UTC(), sub).Sub(time.Now().UTC()) + time.Minute
+	if !texp.hasUpcomingReminder(soon) {
+		t.Errorf("hasUpcomingReminder(%v) = false, want true", soon)
+	}
+
+	if texp.hasUpcomingReminder(time.Second) {
+		t.Errorf("hasUpcomingReminder(%v) = true, want false", time.Second)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type reportRow struct {
+	Activity string
+	IssueRef string
+	Hours    float32
+	Comment  string
+}
+
+type reportUser struct {
+	Name  string
+	Rows  []reportRow
+	Total float32
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`
+<html>
+<head><meta charset="utf-8"></head>
+<body>
+<h2>{{.Project}} - {{.Date}}</h2>
+{{range .Users}}
+<h3>{{.Name}}</h3>
+<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>активность</th><th>задача</th><th>часы</th><th>комментарий</th></tr>
+{{range .Rows}}<tr><td>{{.Activity}}</td><td>{{.IssueRef}}</td><td>{{.Hours}}</td><td>{{.Comment}}</td></tr>
+{{end}}<tr><td colspan="2"><b>итого</b></td><td><b>{{.Total}}</b></td><td></td></tr>
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+// buildReportUsers groups a project's time entries by user for the HTML
+// report, mirroring the grouping exportTimeEntries does for plaintext.
+func buildReportUsers(entries []TimeEntryResponse, p Project) []reportUser {
+	order := make([]int64, 0)
+	byUser := make(map[int64]*reportUser)
+
+	for _, te := range entries {
+		u, ok := byUser[te.User.ID]
+		if !ok {
+			u = &reportUser{Name: te.User.Name}
+			byUser[te.User.ID] = u
+			order = append(order, te.User.ID)
+		}
+
+		u.Rows = append(u.Rows, reportRow{
+			Activity: te.Activity.Name,
+			IssueRef: fmt.Sprintf("%v #%v", p.Tracker, te.Issue.ID),
+			Hours:    te.Hours,
+			Comment:  te.Comments,
+		})
+		u.Total += te.Hours
+	}
+
+	users := make([]reportUser, 0, len(order))
+	for _, userID := range order {
+		users = append(users, *byUser[userID])
+	}
+
+	return users
+}
+
+func renderReportHTML(p Project, date string, entries []TimeEntryResponse) (string, error) {
+	buf := &bytes.Buffer{}
+
+	data := struct {
+		Project string
+		Date    string
+		Users   []reportUser
+	}{
+		Project: p.Name,
+		Date:    date,
+		Users:   buildReportUsers(entries, p),
+	}
+
+	if err := reportTemplate.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("error during rendering report template\n%v", err)
+	}
+
+	return buf.String(), nil
+}
+
+// wkBinary resolves the wkhtmltoimage/wkhtmltopdf binary for mode, looking
+// it up under WK_PATH if set and falling back to $PATH otherwise.
+func wkBinary(mode string) string {
+	name := "wkhtmltoimage"
+	if mode == "pdf" {
+		name = "wkhtmltopdf"
+	}
+
+	if wkPath := os.Getenv("WK_PATH"); wkPath != "" {
+		return filepath.Join(wkPath, name)
+	}
+
+	return name
+}
+
+// sendRenderedReport renders the day's time entries as HTML and converts
+// them to a PNG or PDF via wkhtmltoimage/wkhtmltopdf, sending the result as
+// a photo or document. It reports false (without sending anything) if the
+// binary is missing or the conversion fails, so the caller can fall back to
+// plaintext.
+func (t Texporter) sendRenderedReport(chatID int64, p Project, date string, entries []TimeEntryResponse, mode string) bool {
+	binPath := wkBinary(mode)
+
+	if _, err := exec.LookPath(binPath); err != nil {
+		t.Logger.Errorw("wkhtmltoimage/wkhtmltopdf binary not found, falling back to text",
+			"Binary", binPath,
+			"Error", err,
+		)
+		return false
+	}
+
+	html, err := renderReportHTML(p, date, entries)
+	if err != nil {
+		t.Logger.Errorw("error during rendering HTML report", "Project Name", p.Name, "Error", err)
+		return false
+	}
+
+	htmlFile, err := os.CreateTemp("", "texporter-*.html")
+	if err != nil {
+		t.Logger.Errorw("error during creating temp HTML file", "Error", err)
+		return false
+	}
+	defer os.Remove(htmlFile.Name())
+
+	if _, err := htmlFile.WriteString(html); err != nil {
+		htmlFile.Close()
+		t.Logger.Errorw("error during writing temp HTML file", "Error", err)
+		return false
+	}
+	htmlFile.Close()
+
+	ext := "png"
+	if mode == "pdf" {
+		ext = "pdf"
+	}
+	outPath := htmlFile.Name() + "." + ext
+	defer os.Remove(outPath)
+
+	cmd := exec.Command(binPath, htmlFile.Name(), outPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Logger.Errorw("error during running "+binPath,
+			"Output", string(out),
+			"Error", err,
+		)
+		return false
+	}
+
+	var tgErr error
+	if mode == "pdf" {
+		_, tgErr = t.TelegramBot.Send(tgbotapi.NewDocument(chatID, tgbotapi.FilePath(outPath)))
+	} else {
+		_, tgErr = t.TelegramBot.Send(tgbotapi.NewPhoto(chatID, tgbotapi.FilePath(outPath)))
+	}
+
+	if tgErr != nil {
+		t.Logger.Errorw("error during sending rendered report",
+			"Project Name", p.Name,
+			"Telegram channel ID", chatID,
+			"Error", tgErr,
+		)
+		return false
+	}
+
+	return true
+}
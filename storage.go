@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// session holds the per-chat state machine progress (type -> date -> ready)
+// so that concurrent chats never clobber each other's dialog.
+type session struct {
+	ChatID            int64
+	State             string
+	IsTest            bool
+	Date              string
+	Projects          []string
+	SubscribeProject  string
+	SubscribeSchedule string
+	OutputMode        string // "text" (default), "image" or "pdf"
+}
+
+// subscription is a chat's standing request to be reminded about the members
+// of a project who have no time entries logged yet for the day.
+type subscription struct {
+	ID       int64
+	ChatID   int64
+	Project  string
+	Schedule string // "daily" or "weekday"
+	Time     string // "HH:MM"
+}
+
+// exportAudit is a single record of what was exported, kept for history
+// even across restarts.
+type exportAudit struct {
+	Project      string
+	Date         string
+	User         string
+	ChatID       int64
+	MessageCount int
+}
+
+// Store persists sessions and export history in a SQLite database at
+// TGTX_DATA_PATH.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore opens (and, if needed, creates) the SQLite database at path and
+// ensures the schema exists.
+func NewStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("error during opening sqlite database at '%v'\n%v", path, err)
+	}
+
+	s := &Store{db: db}
+
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Store) migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			chat_id            INTEGER PRIMARY KEY,
+			state              TEXT NOT NULL,
+			is_test            INTEGER NOT NULL,
+			date               TEXT NOT NULL,
+			projects           TEXT NOT NULL,
+			subscribe_project  TEXT NOT NULL DEFAULT '',
+			subscribe_schedule TEXT NOT NULL DEFAULT '',
+			output_mode        TEXT NOT NULL DEFAULT ''
+		);
+		CREATE TABLE IF NOT EXISTS export_audit (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			project      TEXT NOT NULL,
+			date         TEXT NOT NULL,
+			user         TEXT NOT NULL,
+			chat_id      INTEGER NOT NULL,
+			message_count INTEGER NOT NULL,
+			created_at   TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS subscriptions (
+			id       INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id  INTEGER NOT NULL,
+			project  TEXT NOT NULL,
+			schedule TEXT NOT NULL,
+			time     TEXT NOT NULL,
+			UNIQUE(chat_id, project)
+		);
+		CREATE TABLE IF NOT EXISTS chat_settings (
+			chat_id  INTEGER PRIMARY KEY,
+			timezone TEXT NOT NULL DEFAULT ''
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("error during migrating sqlite schema\n%v", err)
+	}
+
+	return nil
+}
+
+// Close closes the underlying database handle.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// LoadSessions reads every in-flight session back, so a restart can resume
+// dialogs instead of forgetting them.
+func (s *Store) LoadSessions() (map[int64]*session, error) {
+	rows, err := s.db.Query(`SELECT chat_id, state, is_test, date, projects, subscribe_project, subscribe_schedule, output_mode FROM sessions`)
+	if err != nil {
+		return nil, fmt.Errorf("error during loading sessions\n%v", err)
+	}
+	defer rows.Close()
+
+	sessions := make(map[int64]*session)
+
+	for rows.Next() {
+		sess := &session{}
+		var isTest int
+		var projects string
+
+		if err := rows.Scan(&sess.ChatID, &sess.State, &isTest, &sess.Date, &projects, &sess.SubscribeProject, &sess.SubscribeSchedule, &sess.OutputMode); err != nil {
+			return nil, fmt.Errorf("error during scanning session row\n%v", err)
+		}
+
+		sess.IsTest = isTest != 0
+
+		if err := json.Unmarshal([]byte(projects), &sess.Projects); err != nil {
+			return nil, fmt.Errorf("error during unmarshaling session projects\n%v", err)
+		}
+
+		sessions[sess.ChatID] = sess
+	}
+
+	return sessions, rows.Err()
+}
+
+// SaveSession writes (or overwrites) the session for its chat.
+func (s *Store) SaveSession(sess *session) error {
+	projects, err := json.Marshal(sess.Projects)
+	if err != nil {
+		return fmt.Errorf("error during marshaling session projects\n%v", err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO sessions (chat_id, state, is_test, date, projects, subscribe_project, subscribe_schedule, output_mode)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET
+			state = excluded.state,
+			is_test = excluded.is_test,
+			date = excluded.date,
+			projects = excluded.projects,
+			subscribe_project = excluded.subscribe_project,
+			subscribe_schedule = excluded.subscribe_schedule,
+			output_mode = excluded.output_mode
+	`, sess.ChatID, sess.State, sess.IsTest, sess.Date, string(projects), sess.SubscribeProject, sess.SubscribeSchedule, sess.OutputMode)
+	if err != nil {
+		return fmt.Errorf("error during saving session for chat %v\n%v", sess.ChatID, err)
+	}
+
+	return nil
+}
+
+// DeleteSession drops a finished or abandoned dialog.
+func (s *Store) DeleteSession(chatID int64) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE chat_id = ?`, chatID)
+	if err != nil {
+		return fmt.Errorf("error during deleting session for chat %v\n%v", chatID, err)
+	}
+
+	return nil
+}
+
+// LogExport records an audit row of a single user's export so the history
+// survives restarts.
+func (s *Store) LogExport(a exportAudit) error {
+	_, err := s.db.Exec(`
+		INSERT INTO export_audit (project, date, user, chat_id, message_count, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, a.Project, a.Date, a.User, a.ChatID, a.MessageCount, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("error during logging export audit row\n%v", err)
+	}
+
+	return nil
+}
+
+// AddSubscription creates or updates a chat's reminder subscription for a
+// project.
+func (s *Store) AddSubscription(sub subscription) error {
+	_, err := s.db.Exec(`
+		INSERT INTO subscriptions (chat_id, project, schedule, time)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(chat_id, project) DO UPDATE SET
+			schedule = excluded.schedule,
+			time = excluded.time
+	`, sub.ChatID, sub.Project, sub.Schedule, sub.Time)
+	if err != nil {
+		return fmt.Errorf("error during adding subscription for chat %v\n%v", sub.ChatID, err)
+	}
+
+	return nil
+}
+
+// RemoveSubscription drops a chat's reminder subscription for a project.
+func (s *Store) RemoveSubscription(chatID int64, project string) error {
+	_, err := s.db.Exec(`DELETE FROM subscriptions WHERE chat_id = ? AND project = ?`, chatID, project)
+	if err != nil {
+		return fmt.Errorf("error during removing subscription for chat %v\n%v", chatID, err)
+	}
+
+	return nil
+}
+
+// ListSubscriptionsByChat returns every subscription a chat currently has.
+func (s *Store) ListSubscriptionsByChat(chatID int64) ([]subscription, error) {
+	rows, err := s.db.Query(`SELECT id, chat_id, project, schedule, time FROM subscriptions WHERE chat_id = ?`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("error during listing subscriptions for chat %v\n%v", chatID, err)
+	}
+	defer rows.Close()
+
+	return scanSubscriptions(rows)
+}
+
+// ListSubscriptions returns every subscription across every chat, for the
+// reminder ticker to walk through.
+func (s *Store) ListSubscriptions() ([]subscription, error) {
+	rows, err := s.db.Query(`SELECT id, chat_id, project, schedule, time FROM subscriptions`)
+	if err != nil {
+		return nil, fmt.Errorf("error during listing subscriptions\n%v", err)
+	}
+	defer rows.Close()
+
+	return scanSubscriptions(rows)
+}
+
+// GetTimezone returns the chat's stored timezone setting, or "" if it never
+// set one.
+func (s *Store) GetTimezone(chatID int64) (string, error) {
+	var timezone string
+
+	err := s.db.QueryRow(`SELECT timezone FROM chat_settings WHERE chat_id = ?`, chatID).Scan(&timezone)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error during reading timezone for chat %v\n%v", chatID, err)
+	}
+
+	return timezone, nil
+}
+
+// SetTimezone persists the chat's timezone setting.
+func (s *Store) SetTimezone(chatID int64, timezone string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO chat_settings (chat_id, timezone)
+		VALUES (?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET timezone = excluded.timezone
+	`, chatID, timezone)
+	if err != nil {
+		return fmt.Errorf("error during saving timezone for chat %v\n%v", chatID, err)
+	}
+
+	return nil
+}
+
+func scanSubscriptions(rows *sql.Rows) ([]subscription, error) {
+	subs := make([]subscription, 0)
+
+	for rows.Next() {
+		sub := subscription{}
+
+		if err := rows.Scan(&sub.ID, &sub.ChatID, &sub.Project, &sub.Schedule, &sub.Time); err != nil {
+			return nil, fmt.Errorf("error during scanning subscription row\n%v", err)
+		}
+
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// parseTimezone accepts either an IANA name ("Europe/Moscow") or a fixed
+// offset ("+03:00", "-05:30") and returns the matching location.
+func parseTimezone(input string) (*time.Location, error) {
+	if loc, err := time.LoadLocation(input); err == nil {
+		return loc, nil
+	}
+
+	sign := 1
+	offset := input
+
+	switch {
+	case strings.HasPrefix(offset, "+"):
+		offset = offset[1:]
+	case strings.HasPrefix(offset, "-"):
+		sign = -1
+		offset = offset[1:]
+	default:
+		return nil, fmt.Errorf("unrecognized timezone %q", input)
+	}
+
+	parts := strings.Split(offset, ":")
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("unrecognized timezone %q\n%v", input, err)
+	}
+
+	minutes := 0
+	if len(parts) > 1 {
+		minutes, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized timezone %q\n%v", input, err)
+		}
+	}
+
+	return time.FixedZone(input, sign*(hours*3600+minutes*60)), nil
+}
+
+// locationFor resolves a chat's stored timezone, falling back to UTC if it
+// never set one or the stored value no longer parses.
+func (t Texporter) locationFor(chatID int64) *time.Location {
+	tz, err := t.Store.GetTimezone(chatID)
+	if err != nil {
+		t.Logger.Errorw("error during reading timezone", "Chat ID", chatID, "Error", err)
+		return time.UTC
+	}
+
+	if tz == "" {
+		return time.UTC
+	}
+
+	loc, err := parseTimezone(tz)
+	if err != nil {
+		t.Logger.Errorw("error during parsing stored timezone", "Chat ID", chatID, "Timezone", tz, "Error", err)
+		return time.UTC
+	}
+
+	return loc
+}
+
+// handleSetTimezone implements "/tz <+03:00|IANA name>".
+func (t Texporter) handleSetTimezone(chatID int64, arg string) (tgbotapi.Chattable, error) {
+	if _, err := parseTimezone(arg); err != nil {
+		return tgbotapi.NewMessage(chatID, "не понимаю этот часовой пояс, пример: /tz +03:00 или /tz Europe/Moscow"), nil
+	}
+
+	if err := t.Store.SetTimezone(chatID, arg); err != nil {
+		t.Logger.Errorw("error during saving timezone", "Chat ID", chatID, "Error", err)
+	}
+
+	return tgbotapi.NewMessage(chatID, fmt.Sprintf("часовой пояс установлен: %v", arg)), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrevWorkDateMondayRollback(t *testing.T) {
+	nyc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("error during loading location\n%v", err)
+	}
+
+	cases := []struct {
+		name   string
+		today  time.Time
+		offset int
+		want   string
+	}{
+		{
+			name:   "ordinary weekday falls back one day",
+			today:  time.Date(2024, 3, 6, 12, 0, 0, 0, nyc), // Wednesday
+			offset: 0,
+			want:   "2024-03-05",
+		},
+		{
+			name:   "monday falls back to friday across the spring-forward DST jump",
+			today:  time.Date(2024, 3, 11, 12, 0, 0, 0, nyc), // Monday, after 2024-03-10 DST start
+			offset: 0,
+			want:   "2024-03-08",
+		},
+		{
+			name:   "monday falls back to friday across the fall-back DST jump",
+			today:  time.Date(2024, 11, 4, 12, 0, 0, 0, nyc), // Monday, after 2024-11-03 DST end
+			offset: 0,
+			want:   "2024-11-01",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := prevWorkDateFrom(c.today, c.offset)
+			if got != c.want {
+				t.Errorf("prevWorkDateFrom(%v, %v) = %v, want %v", c.today, c.offset, got, c.want)
+			}
+		})
+	}
+}